@@ -4,23 +4,48 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/auth"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/cache"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/client"
 	"github.com/day0ops/ext-proc-service-aggregation/pkg/config"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/observability"
 	"github.com/day0ops/ext-proc-service-aggregation/pkg/server"
 	"github.com/day0ops/ext-proc-service-aggregation/pkg/version"
 	service_ext_proc_v3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 )
 
 var (
-	grpcport = flag.String("grpcport", ":18080", "grpcport")
+	grpcport   = flag.String("grpcport", ":18080", "grpcport")
+	configPath = flag.String("config", "", "path to the aggregation config file (YAML or JSON); if unset, the processor mutates nothing")
+
+	requestBodyMode  = flag.String("request-body-mode", string(config.BodySendModeNone), "how request bodies are sent to this processor: none, streamed, buffered or buffered_partial")
+	responseBodyMode = flag.String("response-body-mode", string(config.BodySendModeNone), "how response bodies are sent to this processor: none, streamed, buffered or buffered_partial")
+	processResponse  = flag.Bool("process-response", false, "also process response headers and trailers in addition to the request path")
+
+	metricsAddr          = flag.String("metrics-addr", config.DefaultObservabilityConfig().MetricsAddr, "address to serve Prometheus metrics on; empty disables the metrics listener")
+	tracingEnabled       = flag.Bool("tracing-enabled", config.DefaultObservabilityConfig().TracingEnabled, "export OpenTelemetry traces for aggregation requests via OTLP/HTTP")
+	slowRequestThreshold = flag.Duration("slow-request-threshold", config.DefaultObservabilityConfig().SlowRequestThreshold, "log a warning when aggregating a request takes longer than this")
+
+	cacheEnabled     = flag.Bool("cache-enabled", config.DefaultCacheConfig().Enabled, "cache aggregated results in front of upstream fetches")
+	cacheTTL         = flag.Duration("cache-ttl", config.DefaultCacheConfig().TTL, "how long a successful aggregation result is cached for")
+	cacheNegativeTTL = flag.Duration("cache-negative-ttl", config.DefaultCacheConfig().NegativeTTL, "how long a failed aggregation is cached for, to avoid hammering a struggling upstream; zero disables negative caching")
+	cacheRedisAddr   = flag.String("cache-redis-addr", config.DefaultCacheConfig().RedisAddr, "Redis address backing the cache; empty uses an in-process cache")
+
+	jwtEnabled = flag.Bool("jwt-enabled", config.DefaultJWTConfig().Enabled, "resolve userid from an Authorization: Bearer JWT instead of the raw userid header")
+	jwtClaim   = flag.String("jwt-claim", config.DefaultJWTConfig().Claim, "JWT claim holding the userid")
+	jwtJWKSURL = flag.String("jwt-jwks-url", config.DefaultJWTConfig().JWKSURL, "JWKS URL to verify the JWT's signature against; empty reads claims unverified")
 )
 
 func main() {
@@ -43,6 +68,70 @@ func start() int {
 
 	flag.Parse()
 
+	processingMode := config.ProcessingModeConfig{
+		RequestBodyMode:         config.BodySendMode(*requestBodyMode),
+		ResponseBodyMode:        config.BodySendMode(*responseBodyMode),
+		ProcessResponseHeaders:  *processResponse,
+		ProcessResponseTrailers: *processResponse,
+	}
+
+	observabilityConfig := config.ObservabilityConfig{
+		MetricsAddr:          *metricsAddr,
+		TracingEnabled:       *tracingEnabled,
+		SlowRequestThreshold: *slowRequestThreshold,
+	}
+
+	cacheConfig := config.CacheConfig{
+		Enabled:     *cacheEnabled,
+		TTL:         *cacheTTL,
+		NegativeTTL: *cacheNegativeTTL,
+		RedisAddr:   *cacheRedisAddr,
+	}
+	aggregationCache := cache.New(cacheConfig)
+
+	userIDResolver := auth.NewUserIDResolver(config.JWTConfig{
+		Enabled: *jwtEnabled,
+		Claim:   *jwtClaim,
+		JWKSURL: *jwtJWKSURL,
+	})
+
+	tracer, shutdownTracer, err := observability.NewTracer(observabilityConfig)
+	if err != nil {
+		log.Error("failed to set up tracing", zap.Error(err))
+		return 1
+	}
+	defer func() {
+		if err := shutdownTracer(context.Background()); err != nil {
+			log.Warn("error shutting down tracer", zap.Error(err))
+		}
+	}()
+
+	metrics := observability.NewMetrics()
+	if observabilityConfig.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{}))
+		metricsServer := &http.Server{Addr: observabilityConfig.MetricsAddr, Handler: mux}
+		go func() {
+			log.Info("starting metrics server on port", zap.String("port", observabilityConfig.MetricsAddr))
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("metrics server error", zap.Error(err))
+			}
+		}()
+		defer metricsServer.Close()
+	}
+
+	aggregationConfig := &atomic.Pointer[config.AggregationConfig]{}
+	aggregationConfig.Store(&config.AggregationConfig{})
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	if *configPath != "" {
+		if err := loadAggregationConfig(*configPath, aggregationConfig, log); err != nil {
+			log.Error("failed to load config", zap.String("config", *configPath), zap.Error(err))
+			return 1
+		}
+		watchAggregationConfig(*configPath, aggregationConfig, stopWatch, log)
+	}
+
 	lis, err := net.Listen("tcp", *grpcport)
 	if err != nil {
 		log.Error("failed to listen: %v", zap.Error(err))
@@ -53,11 +142,35 @@ func start() int {
 	s := grpc.NewServer(sopts...)
 
 	// register server
-	service_ext_proc_v3.RegisterExternalProcessorServer(s, &server.Server{Log: log})
+	service_ext_proc_v3.RegisterExternalProcessorServer(s, &server.Server{
+		Log:            log,
+		ProcessingMode: processingMode,
+		Config:         aggregationConfig,
+		Client:         client.New(client.DefaultConfig()),
+		Observability:  observabilityConfig,
+		Metrics:        metrics,
+		Tracer:         tracer,
+		Cache:          aggregationCache,
+		CacheConfig:    cacheConfig,
+		UserIDResolver: userIDResolver,
+	})
 
 	// register for health checks
 	grpc_health_v1.RegisterHealthServer(s, &server.HealthServer{Log: log})
 
+	if *configPath != "" {
+		sighup := make(chan os.Signal, 1)
+		signal.Notify(sighup, syscall.SIGHUP)
+		go func() {
+			for range sighup {
+				log.Info("received SIGHUP, reloading config", zap.String("config", *configPath))
+				if err := loadAggregationConfig(*configPath, aggregationConfig, log); err != nil {
+					log.Error("failed to reload config, keeping previous config", zap.String("config", *configPath), zap.Error(err))
+				}
+			}
+		}()
+	}
+
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 	eg, ctx := errgroup.WithContext(ctx)
@@ -103,3 +216,33 @@ func getLevelLogger(level string) zapcore.Level {
 	}
 	return zap.InfoLevel
 }
+
+// loadAggregationConfig loads the aggregation config at path and, on
+// success, stores it in current. The previous config is left in place on
+// error so that a bad edit never takes the processor's fetch plan down to
+// nothing.
+func loadAggregationConfig(path string, current *atomic.Pointer[config.AggregationConfig], log *zap.Logger) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	current.Store(cfg)
+	log.Info("loaded aggregation config", zap.String("config", path), zap.Int("fetchers", len(cfg.Fetchers)))
+	return nil
+}
+
+// watchAggregationConfig reloads the aggregation config whenever the file at
+// path changes on disk, until stop is closed.
+func watchAggregationConfig(path string, current *atomic.Pointer[config.AggregationConfig], stop <-chan struct{}, log *zap.Logger) {
+	err := config.WatchFile(path, stop, func(cfg *config.AggregationConfig, err error) {
+		if err != nil {
+			log.Error("failed to reload config, keeping previous config", zap.String("config", path), zap.Error(err))
+			return
+		}
+		current.Store(cfg)
+		log.Info("reloaded aggregation config", zap.String("config", path), zap.Int("fetchers", len(cfg.Fetchers)))
+	})
+	if err != nil {
+		log.Warn("could not watch config file for changes, hot-reload via fsnotify disabled (SIGHUP still works)", zap.String("config", path), zap.Error(err))
+	}
+}
@@ -0,0 +1,67 @@
+// Package cache stores aggregated fetcher results in front of the upstream
+// fan-out, keyed by userid and the rendered URLs of the fetchers that
+// produced them.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/config"
+)
+
+// Entry is a cached aggregation result. Negative entries remember that the
+// last attempt for this key failed, so repeated requests don't keep
+// hammering a struggling upstream for NegativeTTL.
+type Entry struct {
+	Body     string
+	ETag     string
+	Negative bool
+}
+
+// Cache stores aggregation results keyed by Key's output.
+type Cache interface {
+	Get(ctx context.Context, key string) (*Entry, bool)
+	Set(ctx context.Context, key string, entry Entry, ttl time.Duration)
+}
+
+// New builds the Cache described by cfg, or nil if caching is disabled.
+// RedisAddr, if set, backs the cache with Redis so entries are shared
+// across replicas; otherwise it is an in-process Memory cache.
+func New(cfg config.CacheConfig) Cache {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.RedisAddr != "" {
+		return NewRedis(cfg.RedisAddr)
+	}
+	return NewMemory()
+}
+
+// Key derives the cache key for a userid and the set of rendered fetcher
+// URLs that contributed to the aggregated result (see
+// server.fetcherKeyParts), so that two requests for the same user but a
+// different fetcher subset, or the same fetchers rendered against a
+// different header (see per-route aggregation profiles and URL template
+// interpolation), never collide.
+func Key(userid string, fetcherParts []string) string {
+	sorted := append([]string(nil), fetcherParts...)
+	sort.Strings(sorted)
+
+	h := sha256.New()
+	h.Write([]byte(userid))
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(sorted, ",")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ETag derives a strong, quoted ETag from an aggregated body: the first 16
+// hex digits of its SHA-256.
+func ETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `"` + hex.EncodeToString(sum[:])[:16] + `"`
+}
@@ -0,0 +1,23 @@
+package cache
+
+import "testing"
+
+func TestKeyDiffersByUserIDAndParts(t *testing.T) {
+	base := Key("alice", []string{"users|https://u/1", "albums|https://a/1"})
+
+	if got := Key("bob", []string{"users|https://u/1", "albums|https://a/1"}); got == base {
+		t.Error("Key() did not change when userid changed")
+	}
+	if got := Key("alice", []string{"users|https://u/1", "albums|https://a/2"}); got == base {
+		t.Error("Key() did not change when a fetcher part (e.g. rendered URL) changed")
+	}
+}
+
+func TestKeyIsOrderIndependent(t *testing.T) {
+	a := Key("alice", []string{"users|u1", "albums|a1"})
+	b := Key("alice", []string{"albums|a1", "users|u1"})
+
+	if a != b {
+		t.Error("Key() should not depend on the order fetcher parts are supplied in")
+	}
+}
@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryItem struct {
+	entry     Entry
+	expiresAt time.Time
+}
+
+// Memory is an in-process Cache. Expired entries are evicted lazily, on the
+// next Get for that key, rather than via a background sweep.
+type Memory struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+// NewMemory builds an empty Memory cache.
+func NewMemory() *Memory {
+	return &Memory{items: make(map[string]memoryItem)}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (*Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	item, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(item.expiresAt) {
+		delete(m.items, key)
+		return nil, false
+	}
+
+	entry := item.entry
+	return &entry, true
+}
+
+func (m *Memory) Set(ctx context.Context, key string, entry Entry, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.items[key] = memoryItem{entry: entry, expiresAt: time.Now().Add(ttl)}
+}
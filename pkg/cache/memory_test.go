@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryGetSetRoundTrip(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	if _, ok := m.Get(ctx, "missing"); ok {
+		t.Fatal("Get() on an unset key: want ok = false")
+	}
+
+	m.Set(ctx, "k", Entry{Body: "hello"}, time.Minute)
+
+	entry, ok := m.Get(ctx, "k")
+	if !ok {
+		t.Fatal("Get() after Set: want ok = true")
+	}
+	if entry.Body != "hello" {
+		t.Errorf("entry.Body = %q, want %q", entry.Body, "hello")
+	}
+}
+
+func TestMemoryExpiresAfterTTL(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	m.Set(ctx, "k", Entry{Body: "hello"}, 10*time.Millisecond)
+
+	if _, ok := m.Get(ctx, "k"); !ok {
+		t.Fatal("Get() immediately after Set: want ok = true")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := m.Get(ctx, "k"); ok {
+		t.Fatal("Get() after TTL elapsed: want ok = false")
+	}
+}
+
+func TestMemoryNegativeEntryRoundTrips(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	m.Set(ctx, "k", Entry{Negative: true}, time.Minute)
+
+	entry, ok := m.Get(ctx, "k")
+	if !ok {
+		t.Fatal("Get() after Set: want ok = true")
+	}
+	if !entry.Negative {
+		t.Error("entry.Negative = false, want true")
+	}
+	if entry.Body != "" {
+		t.Errorf("entry.Body = %q, want empty for a negative entry", entry.Body)
+	}
+}
+
+func TestMemoryNegativeEntryExpiresIndependently(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	m.Set(ctx, "k", Entry{Negative: true}, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := m.Get(ctx, "k"); ok {
+		t.Fatal("Get() after negative TTL elapsed: want ok = false, so a retry is attempted again")
+	}
+}
@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Cache backed by a Redis instance, so cached aggregation
+// results are shared across replicas of the processor.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis builds a Redis cache talking to addr.
+func NewRedis(addr string) *Redis {
+	return &Redis{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (*Entry, bool) {
+	raw, err := r.client.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (r *Redis) Set(ctx context.Context, key string, entry Entry, ttl time.Duration) {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	r.client.Set(ctx, key, raw, ttl)
+}
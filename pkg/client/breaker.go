@@ -0,0 +1,92 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// breakerState is the closed -> open -> half-open circuit breaker state
+// machine, scoped to a single upstream.
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// breaker trips open after failureThreshold consecutive failures against
+// one upstream within failureWindow of each other, rejecting calls without
+// attempting them until openDuration has passed, at which point it allows a
+// single half-open probe through while every other caller keeps being
+// rejected until that probe reports success or failure.
+type breaker struct {
+	mu sync.Mutex
+
+	state            breakerState
+	consecutiveFails int
+	lastFailureAt    time.Time
+	openUntil        time.Time
+
+	failureThreshold int
+	failureWindow    time.Duration
+	openDuration     time.Duration
+}
+
+func newBreaker(failureThreshold int, failureWindow, openDuration time.Duration) *breaker {
+	return &breaker{failureThreshold: failureThreshold, failureWindow: failureWindow, openDuration: openDuration}
+}
+
+// allow reports whether a call may proceed, transitioning an open breaker
+// whose timeout has elapsed into half-open and admitting exactly one probe
+// call for it; every other concurrent caller is rejected until that probe
+// calls recordSuccess or recordFailure.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case stateOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = stateHalfOpen
+		return true
+	case stateHalfOpen:
+		return false
+	default:
+		return true
+	}
+}
+
+func (b *breaker) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutiveFails = 0
+	b.state = stateClosed
+}
+
+func (b *breaker) recordFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == stateHalfOpen {
+		// the probe failed, so go straight back to open.
+		b.state = stateOpen
+		b.openUntil = time.Now().Add(b.openDuration)
+		return
+	}
+
+	now := time.Now()
+	if b.failureWindow > 0 && !b.lastFailureAt.IsZero() && now.Sub(b.lastFailureAt) > b.failureWindow {
+		b.consecutiveFails = 0
+	}
+	b.lastFailureAt = now
+
+	b.consecutiveFails++
+	if b.consecutiveFails >= b.failureThreshold {
+		b.state = stateOpen
+		b.openUntil = now.Add(b.openDuration)
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := newBreaker(3, time.Minute, 10*time.Second)
+
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("call %d: want allowed while closed", i)
+		}
+		b.recordFailure()
+	}
+	if b.state != stateClosed {
+		t.Fatalf("state = %v, want stateClosed before reaching the threshold", b.state)
+	}
+
+	if !b.allow() {
+		t.Fatal("want allowed on the failure that trips the breaker")
+	}
+	b.recordFailure()
+
+	if b.state != stateOpen {
+		t.Fatalf("state = %v, want stateOpen after %d consecutive failures", b.state, 3)
+	}
+	if b.allow() {
+		t.Fatal("want rejected while open")
+	}
+}
+
+func TestBreakerFailuresOutsideWindowDontAccumulate(t *testing.T) {
+	b := newBreaker(2, 10*time.Millisecond, 10*time.Second)
+
+	b.allow()
+	b.recordFailure()
+	if b.consecutiveFails != 1 {
+		t.Fatalf("consecutiveFails = %d, want 1", b.consecutiveFails)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	b.allow()
+	b.recordFailure()
+	if b.state == stateOpen {
+		t.Fatal("want still closed: the second failure is outside the failure window, so the count should reset instead of accumulating")
+	}
+	if b.consecutiveFails != 1 {
+		t.Fatalf("consecutiveFails = %d, want 1 after the window reset", b.consecutiveFails)
+	}
+}
+
+func TestBreakerAllowsExactlyOneHalfOpenProbe(t *testing.T) {
+	b := newBreaker(1, time.Hour, 10*time.Millisecond)
+
+	if !b.allow() {
+		t.Fatal("want allowed on first call while closed")
+	}
+	b.recordFailure()
+	if b.state != stateOpen {
+		t.Fatalf("state = %v, want stateOpen after tripping with threshold 1", b.state)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !b.allow() {
+		t.Fatal("want the first caller after openDuration elapses to be admitted as the half-open probe")
+	}
+	if b.state != stateHalfOpen {
+		t.Fatalf("state = %v, want stateHalfOpen after admitting the probe", b.state)
+	}
+
+	for i := 0; i < 5; i++ {
+		if b.allow() {
+			t.Fatalf("concurrent call %d: want rejected, only one probe may be in flight while half-open", i)
+		}
+	}
+
+	b.recordFailure()
+	if b.state != stateOpen {
+		t.Fatalf("state = %v, want stateOpen after the probe fails", b.state)
+	}
+}
+
+func TestBreakerHalfOpenProbeSuccessCloses(t *testing.T) {
+	b := newBreaker(1, time.Hour, 10*time.Millisecond)
+
+	b.allow()
+	b.recordFailure()
+	time.Sleep(20 * time.Millisecond)
+
+	if !b.allow() {
+		t.Fatal("want the probe admitted")
+	}
+	b.recordSuccess()
+
+	if b.state != stateClosed {
+		t.Fatalf("state = %v, want stateClosed after a successful probe", b.state)
+	}
+	if !b.allow() {
+		t.Fatal("want allowed again once closed")
+	}
+}
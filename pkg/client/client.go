@@ -0,0 +1,245 @@
+// Package client wraps outbound HTTP calls to aggregation upstreams with
+// the resilience behaviour a fan-out ext_proc server needs: shared
+// timeouts and connection pooling, a circuit breaker per upstream,
+// exponential backoff with jitter on retriable failures, and a global cap
+// on concurrent outbound requests.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// retriableStatuses are the upstream response codes worth retrying; 429,
+// 500, 502, 503 and 504 are transient by convention. Other 5xx statuses
+// are not retried.
+var retriableStatuses = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+	http.StatusInternalServerError: true,
+}
+
+// Config tunes the shared *http.Client and the resilience behaviour
+// (circuit breaking, retries, concurrency) applied to every outbound call
+// made through a Client.
+type Config struct {
+	// DialTimeout, ResponseHeaderTimeout and TotalTimeout bound the
+	// underlying *http.Client and its Transport.
+	DialTimeout           time.Duration
+	ResponseHeaderTimeout time.Duration
+	TotalTimeout          time.Duration
+
+	// MaxIdleConnsPerHost tunes the shared transport's connection pool.
+	MaxIdleConnsPerHost int
+
+	// MaxConcurrentRequests bounds how many outbound requests, across all
+	// upstreams, may be in flight at once.
+	MaxConcurrentRequests int
+
+	// MaxRetries is how many additional attempts are made for a retriable
+	// failure (a status in retriableStatuses or a transport error), on top of the
+	// first attempt.
+	MaxRetries int
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff (with
+	// full jitter) applied between retries.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// BreakerFailureThreshold is how many consecutive failures against one
+	// upstream, within BreakerFailureWindow, open its circuit breaker.
+	BreakerFailureThreshold int
+	// BreakerFailureWindow bounds how long a run of failures counts as
+	// "consecutive": a failure more than BreakerFailureWindow after the
+	// previous one restarts the count instead of adding to it.
+	BreakerFailureWindow time.Duration
+	// BreakerOpenDuration is how long an open breaker rejects calls before
+	// allowing a single half-open probe through.
+	BreakerOpenDuration time.Duration
+}
+
+// DefaultConfig returns sane defaults for an aggregation server fanning out
+// to a handful of upstreams.
+func DefaultConfig() Config {
+	return Config{
+		DialTimeout:             2 * time.Second,
+		ResponseHeaderTimeout:   5 * time.Second,
+		TotalTimeout:            10 * time.Second,
+		MaxIdleConnsPerHost:     32,
+		MaxConcurrentRequests:   64,
+		MaxRetries:              2,
+		RetryBaseDelay:          100 * time.Millisecond,
+		RetryMaxDelay:           2 * time.Second,
+		BreakerFailureThreshold: 5,
+		BreakerFailureWindow:    30 * time.Second,
+		BreakerOpenDuration:     30 * time.Second,
+	}
+}
+
+// Client calls upstream HTTP services with shared timeouts, bounded
+// concurrency, retries with backoff, and a per-upstream circuit breaker.
+type Client struct {
+	cfg  Config
+	http *http.Client
+	sem  chan struct{}
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// New builds a Client from cfg.
+func New(cfg Config) *Client {
+	concurrency := cfg.MaxConcurrentRequests
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	return &Client{
+		cfg: cfg,
+		http: &http.Client{
+			Timeout: cfg.TotalTimeout,
+			Transport: &http.Transport{
+				DialContext:           (&net.Dialer{Timeout: cfg.DialTimeout}).DialContext,
+				ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+				MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+			},
+		},
+		sem:      make(chan struct{}, concurrency),
+		breakers: make(map[string]*breaker),
+	}
+}
+
+func (c *Client) breakerFor(upstream string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.breakers[upstream]
+	if !ok {
+		b = newBreaker(c.cfg.BreakerFailureThreshold, c.cfg.BreakerFailureWindow, c.cfg.BreakerOpenDuration)
+		c.breakers[upstream] = b
+	}
+	return b
+}
+
+// Do calls method/url, applying the circuit breaker, retry, and
+// concurrency-limiting policies configured on c. upstream identifies the
+// logical fetcher for breaker purposes (typically the fetcher name), not
+// the URL, so repeated calls to the same upstream share one breaker.
+// headers, if non-nil, are set on every attempt's request (e.g. a
+// propagated traceparent); it may be nil. On success, the caller is
+// responsible for closing the response body.
+func (c *Client) Do(ctx context.Context, upstream, method, url string, headers map[string]string) (*http.Response, error) {
+	b := c.breakerFor(upstream)
+	if !b.allow() {
+		return nil, &Error{Upstream: upstream, Reason: ReasonCircuitOpen, Err: fmt.Errorf("circuit breaker is open")}
+	}
+
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		// allow() may have just admitted this call as the sole half-open
+		// probe; record the outcome even though no request was attempted,
+		// or the breaker would stay half-open forever with no further
+		// caller ever allowed through to retry it.
+		b.recordFailure()
+		return nil, &Error{Upstream: upstream, Reason: ReasonTimeout, Err: ctx.Err()}
+	}
+	defer func() { <-c.sem }()
+
+	var lastErr error
+	attempts := 0
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		attempts++
+		if attempt > 0 {
+			if err := sleepBackoff(ctx, c.cfg.RetryBaseDelay, c.cfg.RetryMaxDelay, attempt); err != nil {
+				lastErr = err
+				break
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, url, nil)
+		if err != nil {
+			// Same reasoning as the ctx.Done() case above: the breaker may
+			// be expecting this call's outcome to clear its half-open probe.
+			b.recordFailure()
+			return nil, &Error{Upstream: upstream, Reason: ReasonUpstream, Attempts: attempts, Err: err}
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := c.http.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if retriableStatuses[resp.StatusCode] {
+			_ = resp.Body.Close()
+			if attempt < c.cfg.MaxRetries {
+				lastErr = fmt.Errorf("retriable status %d", resp.StatusCode)
+				continue
+			}
+			b.recordFailure()
+			return nil, &Error{Upstream: upstream, Reason: ReasonUpstream, Attempts: attempts, Err: fmt.Errorf("retriable status %d after %d attempt(s)", resp.StatusCode, attempts)}
+		}
+
+		if resp.StatusCode >= 500 {
+			_ = resp.Body.Close()
+			b.recordFailure()
+			return nil, &Error{Upstream: upstream, Reason: ReasonUpstream, Attempts: attempts, Err: fmt.Errorf("non-retriable status %d", resp.StatusCode)}
+		}
+
+		// 4xx (and below) is the upstream responding, not the upstream
+		// failing - it does not trip the breaker or become a *client.Error,
+		// since that would surface as an aggregation-wide 502/504 for what
+		// is often a caller mistake (bad request, missing auth) rather than
+		// an upstream outage. It's returned as-is; decoding it is the
+		// caller's problem, same as any other fetch result.
+		b.recordSuccess()
+		return resp, nil
+	}
+
+	b.recordFailure()
+	reason := ReasonUpstream
+	if isTimeout(ctx, lastErr) {
+		reason = ReasonTimeout
+	}
+	return nil, &Error{Upstream: upstream, Reason: reason, Attempts: attempts, Err: lastErr}
+}
+
+func isTimeout(ctx context.Context, err error) bool {
+	if ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	var netErr net.Error
+	return err != nil && errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// sleepBackoff waits out attempt's exponential backoff interval, with full
+// jitter, or returns ctx.Err() if ctx is done first.
+func sleepBackoff(ctx context.Context, base, max time.Duration, attempt int) error {
+	delay := base << (attempt - 1)
+	if max > 0 && delay > max {
+		delay = max
+	}
+	if delay > 0 {
+		delay = time.Duration(rand.Int63n(int64(delay)))
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// probeBreaker puts c's breaker for upstream into the state Do would find it
+// in right after allow() admits a half-open probe, without going through a
+// real failing call first.
+func probeBreaker(c *Client, upstream string) *breaker {
+	b := c.breakerFor(upstream)
+	b.state = stateOpen
+	b.openUntil = time.Now().Add(-time.Second)
+	return b
+}
+
+func TestDoRecordsFailureWhenContextDoneWhileWaitingForSemaphore(t *testing.T) {
+	c := New(Config{MaxConcurrentRequests: 1, TotalTimeout: time.Second})
+	c.sem <- struct{}{} // occupy the only slot so Do blocks waiting for it
+
+	b := probeBreaker(c, "up")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := c.Do(ctx, "up", "GET", "http://example.invalid", nil); err == nil {
+		t.Fatal("Do() error = nil, want an error since ctx is already done")
+	}
+
+	if b.state != stateOpen {
+		t.Fatalf("breaker state = %v, want stateOpen: a probe that never ran must not leave the breaker wedged half-open forever", b.state)
+	}
+}
+
+func TestDoRecordsFailureWhenRequestConstructionFails(t *testing.T) {
+	c := New(Config{MaxConcurrentRequests: 1, TotalTimeout: time.Second})
+
+	b := probeBreaker(c, "up")
+
+	if _, err := c.Do(context.Background(), "up", "BAD METHOD", "http://example.invalid", nil); err == nil {
+		t.Fatal("Do() error = nil, want an error for an invalid HTTP method")
+	}
+
+	if b.state != stateOpen {
+		t.Fatalf("breaker state = %v, want stateOpen: a probe that fails to build a request must not leave the breaker wedged half-open forever", b.state)
+	}
+}
+
+func TestSleepBackoffStaysWithinBounds(t *testing.T) {
+	base := 10 * time.Millisecond
+	max := 50 * time.Millisecond
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		start := time.Now()
+		if err := sleepBackoff(context.Background(), base, max, attempt); err != nil {
+			t.Fatalf("attempt %d: unexpected error %v", attempt, err)
+		}
+		elapsed := time.Since(start)
+		if elapsed > max+10*time.Millisecond {
+			t.Fatalf("attempt %d: slept %v, want at most ~%v (RetryMaxDelay)", attempt, elapsed, max)
+		}
+	}
+}
+
+func TestSleepBackoffReturnsContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sleepBackoff(ctx, time.Second, time.Second, 1); err != ctx.Err() {
+		t.Fatalf("sleepBackoff() error = %v, want %v", err, ctx.Err())
+	}
+}
@@ -0,0 +1,47 @@
+package client
+
+import "fmt"
+
+// Reason classifies why a call to an upstream ultimately failed, so callers
+// can decide what to surface back to their own caller (e.g. which HTTP
+// status to return to Envoy via an ImmediateResponse).
+type Reason string
+
+const (
+	// ReasonCircuitOpen means the upstream's circuit breaker was open, so
+	// no request was even attempted.
+	ReasonCircuitOpen Reason = "circuit_open"
+	// ReasonTimeout means the request, or all of its retries, exceeded
+	// their configured timeout.
+	ReasonTimeout Reason = "timeout"
+	// ReasonUpstream means the upstream returned a non-retriable error
+	// status, or all retries against it were exhausted.
+	ReasonUpstream Reason = "upstream_error"
+)
+
+// Error is returned by Client.Do when an outbound call ultimately fails, in
+// place of the log.Fatal calls this used to trigger.
+type Error struct {
+	Upstream string
+	Reason   Reason
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("upstream %q: %s after %d attempt(s): %v", e.Upstream, e.Reason, e.Attempts, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status that best reflects this failure to a
+// downstream caller: 504 when the upstream was simply too slow, 502
+// otherwise.
+func (e *Error) StatusCode() int {
+	if e.Reason == ReasonTimeout {
+		return 504
+	}
+	return 502
+}
@@ -0,0 +1,96 @@
+package config
+
+// MergeStrategy controls how a Fetcher's result is combined into the
+// aggregated response.
+type MergeStrategy string
+
+const (
+	// MergeKey (the default) places the fetcher's result under TargetField,
+	// or Name if TargetField is unset, as a single key in the response.
+	MergeKey MergeStrategy = "key"
+	// MergeAppend appends the fetcher's result onto an array at
+	// TargetField, or Name if TargetField is unset, creating it if absent.
+	// The result itself may be a JSON
+	// array, in which case its elements are appended individually.
+	MergeAppend MergeStrategy = "append"
+	// MergeJSONPath merges the fetcher's result into the aggregated
+	// response at the dotted path described by TargetField (e.g.
+	// "profile.albums"), or Name if TargetField is unset, creating
+	// intermediate objects as needed.
+	MergeJSONPath MergeStrategy = "jsonpath"
+)
+
+// Fetcher describes a single named upstream call that contributes to the
+// aggregated response.
+type Fetcher struct {
+	// Name identifies the fetcher in logs/metrics and, absent TargetField,
+	// is also the key it is merged under in the aggregated response.
+	Name string `yaml:"name" json:"name"`
+
+	// URLTemplate is a text/template string rendered against a
+	// TemplateContext built from the incoming request to produce the
+	// upstream URL, e.g.
+	// "https://jsonplaceholder.typicode.com/users/{{ .Headers.userid }}/albums".
+	URLTemplate string `yaml:"url" json:"url"`
+
+	// Method is the HTTP method used to call the upstream. Defaults to GET.
+	Method string `yaml:"method" json:"method"`
+
+	// Timeout bounds how long this fetcher is allowed to take, written as a
+	// duration string (e.g. "5s"). Zero sets no per-fetcher timeout; the
+	// shared client's own timeouts still apply.
+	Timeout Duration `yaml:"timeout" json:"timeout"`
+
+	// TargetField names where in the aggregated response this fetcher's
+	// result is merged; its meaning depends on Merge.
+	TargetField string `yaml:"targetField" json:"targetField"`
+
+	// Merge selects how the result is combined into the aggregated
+	// response. Defaults to MergeKey.
+	Merge MergeStrategy `yaml:"merge" json:"merge"`
+}
+
+// AggregationConfig is the top-level declarative configuration for the
+// ext_proc aggregation server, loaded from YAML/JSON by Load.
+type AggregationConfig struct {
+	// Fetchers is the fan-out plan: every entry is called, in parallel, for
+	// each request that reaches the aggregation phase.
+	Fetchers []Fetcher `yaml:"fetchers" json:"fetchers"`
+
+	// Profiles names subsets of Fetchers, by fetcher name, so a single
+	// deployment can serve multiple routes with different fan-out plans.
+	// Which profile applies to a request is selected by Envoy metadata (see
+	// the "aggregation.profile" filter metadata field); an unrecognised or
+	// absent profile falls back to the full Fetchers list.
+	Profiles map[string][]string `yaml:"profiles" json:"profiles"`
+}
+
+// FetchersForProfile returns the subset of cfg.Fetchers named by profile in
+// cfg.Profiles, preserving Fetchers' original order. If profile is empty or
+// not a known profile, every configured fetcher is returned.
+func (cfg *AggregationConfig) FetchersForProfile(profile string) []Fetcher {
+	names, ok := cfg.Profiles[profile]
+	if profile == "" || !ok {
+		return cfg.Fetchers
+	}
+
+	wanted := make(map[string]bool, len(names))
+	for _, n := range names {
+		wanted[n] = true
+	}
+
+	selected := make([]Fetcher, 0, len(names))
+	for _, f := range cfg.Fetchers {
+		if wanted[f.Name] {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
+
+// TemplateContext is the data made available to a Fetcher's URLTemplate.
+type TemplateContext struct {
+	// Headers holds the incoming request headers, keyed by lower-cased
+	// header name.
+	Headers map[string]string
+}
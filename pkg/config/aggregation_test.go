@@ -0,0 +1,64 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFetchersForProfile(t *testing.T) {
+	cfg := &AggregationConfig{
+		Fetchers: []Fetcher{
+			{Name: "users"},
+			{Name: "albums"},
+			{Name: "posts"},
+		},
+		Profiles: map[string][]string{
+			"mobile": {"posts", "users"},
+			"empty":  {},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		profile string
+		want    []string
+	}{
+		{
+			name:    "unknown profile falls back to every fetcher",
+			profile: "does-not-exist",
+			want:    []string{"users", "albums", "posts"},
+		},
+		{
+			name:    "empty profile falls back to every fetcher",
+			profile: "",
+			want:    []string{"users", "albums", "posts"},
+		},
+		{
+			name:    "known profile preserves Fetchers order, not Profiles order",
+			profile: "mobile",
+			want:    []string{"users", "posts"},
+		},
+		{
+			name:    "profile naming no fetchers selects none",
+			profile: "empty",
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := namesOf(cfg.FetchersForProfile(tt.profile))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FetchersForProfile(%q) = %v, want %v", tt.profile, got, tt.want)
+			}
+		})
+	}
+}
+
+func namesOf(fetchers []Fetcher) []string {
+	names := make([]string, len(fetchers))
+	for i, f := range fetchers {
+		names[i] = f.Name
+	}
+	return names
+}
@@ -0,0 +1,32 @@
+package config
+
+import "time"
+
+// CacheConfig controls the cache in front of fetchAggregatedResources.
+type CacheConfig struct {
+	// Enabled turns the cache on. When false, every request fans out to the
+	// configured fetchers.
+	Enabled bool
+
+	// TTL is how long a successful aggregation result is cached for.
+	TTL time.Duration
+
+	// NegativeTTL is how long a failed aggregation is cached for, to avoid
+	// hammering an already-struggling set of upstreams. Zero disables
+	// negative caching.
+	NegativeTTL time.Duration
+
+	// RedisAddr, if set, backs the cache with Redis at this address instead
+	// of an in-process map, so entries are shared across replicas.
+	RedisAddr string
+}
+
+// DefaultCacheConfig returns the cache disabled, so existing deployments
+// that don't opt in see no behaviour change.
+func DefaultCacheConfig() CacheConfig {
+	return CacheConfig{
+		Enabled:     false,
+		TTL:         30 * time.Second,
+		NegativeTTL: 5 * time.Second,
+	}
+}
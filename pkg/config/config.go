@@ -0,0 +1,49 @@
+package config
+
+// LogLevel controls the verbosity of the server's structured logger.
+// "debug" enables debug-level logging, anything else falls back to info.
+var LogLevel = "info"
+
+// BodySendMode mirrors the Envoy ext_proc ProcessingMode body send modes,
+// expressed as plain strings so they are easy to set via flags or config
+// files without pulling in the xDS enum at the call site.
+type BodySendMode string
+
+const (
+	// BodySendModeNone means the body is not sent to the processor at all.
+	BodySendModeNone BodySendMode = "none"
+	// BodySendModeStreamed sends the body to the processor in pieces as it
+	// arrives, without buffering it in Envoy.
+	BodySendModeStreamed BodySendMode = "streamed"
+	// BodySendModeBuffered buffers the entire body in Envoy and sends it to
+	// the processor in one message.
+	BodySendModeBuffered BodySendMode = "buffered"
+	// BodySendModeBufferedPartial buffers the body up to Envoy's configured
+	// limit and sends it in chunks, without waiting for the full body.
+	BodySendModeBufferedPartial BodySendMode = "buffered_partial"
+)
+
+// ProcessingModeConfig controls which ext_proc phases this server
+// participates in and how request/response bodies are streamed to it.
+type ProcessingModeConfig struct {
+	// RequestBodyMode and ResponseBodyMode select how Envoy delivers the
+	// request/response body to the processor.
+	RequestBodyMode  BodySendMode
+	ResponseBodyMode BodySendMode
+
+	// ProcessResponseHeaders and ProcessResponseTrailers enable processing
+	// of the corresponding response-path phases.
+	ProcessResponseHeaders  bool
+	ProcessResponseTrailers bool
+}
+
+// DefaultProcessingMode preserves the server's original behaviour: only
+// request headers are mutated, bodies and the response path are left alone.
+func DefaultProcessingMode() ProcessingModeConfig {
+	return ProcessingModeConfig{
+		RequestBodyMode:         BodySendModeNone,
+		ResponseBodyMode:        BodySendModeNone,
+		ProcessResponseHeaders:  false,
+		ProcessResponseTrailers: false,
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDurationUnmarshalYAML(t *testing.T) {
+	var d Duration
+	if err := yaml.Unmarshal([]byte("5s"), &d); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if d.Duration() != 5*time.Second {
+		t.Errorf("d = %v, want 5s", d.Duration())
+	}
+}
+
+func TestDurationUnmarshalYAMLRejectsBareNumber(t *testing.T) {
+	var d Duration
+	if err := yaml.Unmarshal([]byte("5"), &d); err == nil {
+		t.Fatalf("Unmarshal(%q) = %v, want an error: a bare number is not a valid duration string", "5", d.Duration())
+	}
+}
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	var d Duration
+	if err := json.Unmarshal([]byte(`"250ms"`), &d); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if d.Duration() != 250*time.Millisecond {
+		t.Errorf("d = %v, want 250ms", d.Duration())
+	}
+}
+
+func TestFetcherTimeoutParsesFromYAML(t *testing.T) {
+	var f Fetcher
+	doc := "name: users\nurl: https://example.com\ntimeout: 5s\n"
+	if err := yaml.Unmarshal([]byte(doc), &f); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if f.Timeout.Duration() != 5*time.Second {
+		t.Errorf("f.Timeout = %v, want 5s", f.Timeout.Duration())
+	}
+}
@@ -0,0 +1,28 @@
+package config
+
+// JWTConfig controls how the aggregation userid is resolved from an
+// Authorization: Bearer token, as an alternative to the raw "userid"
+// header.
+type JWTConfig struct {
+	// Enabled turns on Bearer token parsing. When false, userid is read
+	// only from the "userid" header.
+	Enabled bool
+
+	// Claim is the JWT claim holding the userid. Defaults to "sub".
+	Claim string
+
+	// JWKSURL, if set, is fetched to verify a token's signature before its
+	// claims are trusted. Empty means claims are read without verifying
+	// the signature, which is only safe behind a trusted ingress that has
+	// already validated the token.
+	JWKSURL string
+}
+
+// DefaultJWTConfig returns Bearer token parsing disabled, so existing
+// deployments keep resolving userid from the raw header.
+func DefaultJWTConfig() JWTConfig {
+	return JWTConfig{
+		Enabled: false,
+		Claim:   "sub",
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads and parses the aggregation config file at path. Both YAML and
+// JSON are accepted, since JSON is a valid subset of YAML.
+func Load(path string) (*AggregationConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config %s: %w", path, err)
+	}
+
+	var cfg AggregationConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// WatchFile reloads the config file at path whenever it is written to, and
+// hands the result to onChange, until stop is closed. onChange is called
+// with a non-nil error, and a nil config, if the reload fails; callers
+// should keep serving the last-known-good config in that case. WatchFile
+// itself only returns an error if the watch could not be set up at all.
+func WatchFile(path string, stop <-chan struct{}, onChange func(*AggregationConfig, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating file watcher: %w", err)
+	}
+
+	// Watch the containing directory rather than the file itself so that
+	// editors/config-management tools that write via rename-into-place
+	// still trigger a reload.
+	dir := filepath.Dir(path)
+	if err := watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return fmt.Errorf("watching %s: %w", dir, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != filepath.Clean(path) {
+					continue
+				}
+				if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				cfg, err := Load(path)
+				onChange(cfg, err)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onChange(nil, err)
+			}
+		}
+	}()
+
+	return nil
+}
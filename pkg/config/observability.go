@@ -0,0 +1,31 @@
+package config
+
+import "time"
+
+// ObservabilityConfig controls the metrics/tracing behaviour of the server,
+// as opposed to AggregationConfig which controls what it does.
+type ObservabilityConfig struct {
+	// MetricsAddr is the address the Prometheus registry is served on, e.g.
+	// ":9090". Empty disables the metrics listener.
+	MetricsAddr string
+
+	// TracingEnabled gates OpenTelemetry span recording and export. When
+	// false, Process and upstream fetches still run and no spans are
+	// recorded or exported, but an incoming traceparent is still extracted
+	// and forwarded on upstream calls.
+	TracingEnabled bool
+
+	// SlowRequestThreshold is how long aggregation for a single request may
+	// take before it is logged at warn level. Zero disables the check.
+	SlowRequestThreshold time.Duration
+}
+
+// DefaultObservabilityConfig returns metrics enabled on the conventional
+// port, tracing disabled, and a 1s slow-request threshold.
+func DefaultObservabilityConfig() ObservabilityConfig {
+	return ObservabilityConfig{
+		MetricsAddr:          ":9090",
+		TracingEnabled:       false,
+		SlowRequestThreshold: 1 * time.Second,
+	}
+}
@@ -0,0 +1,90 @@
+// Package observability instruments the ext_proc pipeline with Prometheus
+// metrics and OpenTelemetry tracing.
+package observability
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics holds every Prometheus collector emitted by the server, registered
+// against a private Registry rather than the global default so the metrics
+// listener only ever serves what this package defines.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	// RequestsByPhase counts ProcessingRequest messages handled, by
+	// ext_proc phase (request_headers, request_body, response_headers, ...).
+	RequestsByPhase *prometheus.CounterVec
+
+	// AggregationDuration times fetchAggregatedResources: fanning out to
+	// every configured fetcher and merging the results.
+	AggregationDuration prometheus.Histogram
+
+	// UpstreamDuration times individual fetcher calls, broken down by
+	// fetcher name and outcome status ("ok", "error").
+	UpstreamDuration *prometheus.HistogramVec
+
+	// InFlightStreams tracks the number of open ext_proc Process streams.
+	InFlightStreams prometheus.Gauge
+
+	// BodyMutationBytes records the size of body mutations written back to
+	// Envoy, i.e. the aggregated payload replacing the request body.
+	BodyMutationBytes prometheus.Histogram
+
+	// CacheResults counts cache lookups against fetchAggregatedResources, by
+	// outcome ("hit", "miss", "bypass").
+	CacheResults *prometheus.CounterVec
+
+	// SingleflightShared counts aggregation calls that were satisfied by a
+	// concurrent call for the same key rather than fanning out themselves.
+	SingleflightShared prometheus.Counter
+}
+
+// NewMetrics builds and registers the full set of collectors.
+func NewMetrics() *Metrics {
+	reg := prometheus.NewRegistry()
+	factory := promauto.With(reg)
+
+	return &Metrics{
+		Registry: reg,
+
+		RequestsByPhase: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "ext_proc_requests_total",
+			Help: "Total number of ext_proc ProcessingRequest messages handled, by phase.",
+		}, []string{"phase"}),
+
+		AggregationDuration: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ext_proc_aggregation_duration_seconds",
+			Help:    "Time spent fanning out to and merging all configured fetchers for one request.",
+			Buckets: prometheus.DefBuckets,
+		}),
+
+		UpstreamDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ext_proc_upstream_duration_seconds",
+			Help:    "Latency of individual upstream fetcher calls, by fetcher name and outcome status.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"fetcher", "status"}),
+
+		InFlightStreams: factory.NewGauge(prometheus.GaugeOpts{
+			Name: "ext_proc_in_flight_streams",
+			Help: "Number of ext_proc Process streams currently open.",
+		}),
+
+		BodyMutationBytes: factory.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ext_proc_body_mutation_bytes",
+			Help:    "Size in bytes of body mutations written back to Envoy.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 8),
+		}),
+
+		CacheResults: factory.NewCounterVec(prometheus.CounterOpts{
+			Name: "ext_proc_cache_results_total",
+			Help: "Cache lookups against the aggregated result cache, by outcome.",
+		}, []string{"result"}),
+
+		SingleflightShared: factory.NewCounter(prometheus.CounterOpts{
+			Name: "ext_proc_singleflight_shared_total",
+			Help: "Aggregation calls satisfied by a concurrent in-flight call for the same key.",
+		}),
+	}
+}
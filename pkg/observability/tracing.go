@@ -0,0 +1,93 @@
+package observability
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer wraps the otel tracer used across the ext_proc pipeline along with
+// the propagator used to extract the incoming traceparent and inject it into
+// outgoing upstream calls.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// NewTracer builds a Tracer from cfg. When cfg.TracingEnabled is false, the
+// returned Tracer is backed by otel's no-op implementation, an empty
+// shutdown func, and a nil error, so callers never need to branch on
+// whether tracing is on.
+func NewTracer(cfg config.ObservabilityConfig) (*Tracer, func(context.Context) error, error) {
+	propagator := propagation.TraceContext{}
+
+	if !cfg.TracingEnabled {
+		return &Tracer{tracer: otel.Tracer("ext-proc-service-aggregation"), propagator: propagator}, func(context.Context) error { return nil }, nil
+	}
+
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("building otlp trace exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceName("ext-proc-service-aggregation")))
+	if err != nil {
+		return nil, nil, fmt.Errorf("building trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return &Tracer{tracer: tp.Tracer("ext-proc-service-aggregation"), propagator: propagator}, tp.Shutdown, nil
+}
+
+// headerCarrier adapts the plain map[string]string built from an ext_proc
+// HttpHeaders message to propagation.TextMapCarrier.
+type headerCarrier map[string]string
+
+func (h headerCarrier) Get(key string) string { return h[key] }
+
+func (h headerCarrier) Set(key, value string) { h[key] = value }
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// StartRequestSpan extracts a remote trace context from the incoming
+// request's headers (as built by headersToMap) and starts a child span for
+// the ext_proc processing of that request.
+func (t *Tracer) StartRequestSpan(ctx context.Context, headers map[string]string) (context.Context, trace.Span) {
+	ctx = t.propagator.Extract(ctx, headerCarrier(headers))
+	return t.tracer.Start(ctx, "ext_proc.aggregate")
+}
+
+// StartUpstreamSpan starts a child span for a single upstream fetch.
+func (t *Tracer) StartUpstreamSpan(ctx context.Context, fetcher string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "ext_proc.fetch."+fetcher)
+}
+
+// Propagate returns the W3C traceparent headers for ctx's span, ready to be
+// passed to client.Client.Do so an outgoing upstream call joins the same
+// distributed trace.
+func (t *Tracer) Propagate(ctx context.Context) map[string]string {
+	carrier := headerCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	return carrier
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/cache"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/config"
+	service_ext_proc_v3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// headersToMap collects the HTTP headers on an ext_proc request into a
+// plain map, keyed by lower-cased header name, for use as the template
+// interpolation context when rendering a Fetcher's URLTemplate.
+func headersToMap(in *service_ext_proc_v3.HttpHeaders) map[string]string {
+	raw := in.GetHeaders().GetHeaders()
+	headers := make(map[string]string, len(raw))
+	for _, h := range raw {
+		value := h.Value
+		if len(h.RawValue) > 0 {
+			value = string(h.RawValue)
+		}
+		headers[strings.ToLower(h.Key)] = value
+	}
+	return headers
+}
+
+// fetchResult is one upstream fetcher's decoded JSON response, ready to be
+// merged into the aggregated payload.
+type fetchResult struct {
+	fetcher config.Fetcher
+	data    any
+	err     error
+}
+
+// sfResult is what singleflight.Group.Do shares across collapsed callers of
+// runFetchers.
+type sfResult struct {
+	body          string
+	fetcherErrors int
+}
+
+// fetchAggregatedResources runs profile's fetcher plan (see
+// config.AggregationConfig.FetchersForProfile) concurrently against the
+// given request headers and merges their results into a single aggregated
+// JSON document. The returned aggregationOutcome.Body is "" if no fetchers
+// are configured; a non-nil error - wrapping a *client.Error where the
+// failure was an upstream call - is returned if any fetcher fails, since a
+// partial aggregation is of little use to the caller.
+//
+// When s.Cache is configured, the result is looked up and stored under a key
+// derived from the resolved userid and each fetcher's rendered URL, so a repeat request
+// for the same user within the cache's TTL is served without contacting any
+// upstream; a "Cache-Control: no-cache" request header bypasses this.
+// Concurrent misses for the same key are collapsed by s.sf into a single
+// fan-out.
+func (s *Server) fetchAggregatedResources(ctx context.Context, headers map[string]string, profile string) (aggregationOutcome, error) {
+	fetchers := s.Config.Load().FetchersForProfile(profile)
+	if len(fetchers) == 0 {
+		return aggregationOutcome{}, nil
+	}
+
+	if s.Cache == nil || strings.Contains(strings.ToLower(headers["cache-control"]), "no-cache") {
+		s.Metrics.CacheResults.WithLabelValues("bypass").Inc()
+		body, fetcherErrors, err := s.runFetchers(ctx, fetchers, headers)
+		return aggregationOutcome{Body: body, CacheResult: "bypass", FetcherErrors: fetcherErrors}, err
+	}
+
+	key := cache.Key(headers["userid"], fetcherKeyParts(fetchers, headers))
+
+	if entry, ok := s.Cache.Get(ctx, key); ok {
+		s.Metrics.CacheResults.WithLabelValues("hit").Inc()
+		if entry.Negative {
+			return aggregationOutcome{CacheResult: "hit"}, fmt.Errorf("aggregation failed on a previous attempt within the negative-cache window")
+		}
+		return aggregationOutcome{Body: entry.Body, CacheResult: "hit"}, nil
+	}
+	s.Metrics.CacheResults.WithLabelValues("miss").Inc()
+
+	// singleflight reports shared to the caller that ran the fan-out as
+	// well, so only count the callers that reused its result.
+	leader := false
+	v, err, shared := s.sf.Do(key, func() (any, error) {
+		leader = true
+		body, fetcherErrors, err := s.runFetchers(ctx, fetchers, headers)
+		return sfResult{body: body, fetcherErrors: fetcherErrors}, err
+	})
+	if shared && !leader {
+		s.Metrics.SingleflightShared.Inc()
+	}
+	res, _ := v.(sfResult)
+	if err != nil {
+		if s.CacheConfig.NegativeTTL > 0 {
+			s.Cache.Set(ctx, key, cache.Entry{Negative: true}, s.CacheConfig.NegativeTTL)
+		}
+		return aggregationOutcome{CacheResult: "miss", FetcherErrors: res.fetcherErrors}, err
+	}
+
+	s.Cache.Set(ctx, key, cache.Entry{Body: res.body, ETag: cache.ETag(res.body)}, s.CacheConfig.TTL)
+	return aggregationOutcome{Body: res.body, CacheResult: "miss"}, nil
+}
+
+// fetcherKeyParts renders each fetcher's URL template against headers, for
+// use as part of a cache key (see cache.Key). URLTemplate can interpolate
+// any request header (see config.Fetcher), so the rendered URL - not just
+// the fetcher's name - must feed the key, or two requests that differ only
+// in an interpolated header (e.g. a region header) would collide and share
+// a cached aggregate. A fetcher whose template fails to render contributes
+// its name and the render error instead, so a bad template can't collide
+// with a working one; runFetchers will surface the real error to the
+// caller.
+func fetcherKeyParts(fetchers []config.Fetcher, headers map[string]string) []string {
+	parts := make([]string, len(fetchers))
+	for i, f := range fetchers {
+		url, err := renderURL(f, headers)
+		if err != nil {
+			parts[i] = f.Name + "|err:" + err.Error()
+			continue
+		}
+		parts[i] = f.Name + "|" + url
+	}
+	return parts
+}
+
+// runFetchers fans out to fetchers concurrently against the given request
+// headers and merges their results into a single aggregated JSON document.
+// It returns the number of fetchers that failed alongside the first
+// failure's error, which aborts the aggregation since a partial result is
+// of little use to the caller.
+func (s *Server) runFetchers(ctx context.Context, fetchers []config.Fetcher, headers map[string]string) (string, int, error) {
+	start := time.Now()
+
+	results := make([]fetchResult, len(fetchers))
+	var wg sync.WaitGroup
+	for i, f := range fetchers {
+		wg.Add(1)
+		go func(i int, f config.Fetcher) {
+			defer wg.Done()
+			data, err := s.runFetcher(ctx, f, headers)
+			results[i] = fetchResult{fetcher: f, data: data, err: err}
+		}(i, f)
+	}
+	wg.Wait()
+
+	s.Log.Info("fetching took", zap.Duration("duration", time.Since(start)))
+
+	aggregated := map[string]any{}
+	fetcherErrors := 0
+	var firstErr error
+	for _, r := range results {
+		if r.err != nil {
+			fetcherErrors++
+			s.Log.Error("fetcher failed", zap.String("fetcher", r.fetcher.Name), zap.Error(r.err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("fetcher %q: %w", r.fetcher.Name, r.err)
+			}
+			continue
+		}
+		mergeResult(aggregated, r.fetcher, r.data)
+	}
+	if firstErr != nil {
+		return "", fetcherErrors, firstErr
+	}
+
+	data, err := json.Marshal(aggregated)
+	if err != nil {
+		return "", fetcherErrors, fmt.Errorf("marshalling aggregated data: %w", err)
+	}
+
+	return string(data), fetcherErrors, nil
+}
+
+// runFetcher renders f's URL template against headers, calls the upstream
+// through the resilient client.Client (timeouts, retries, circuit
+// breaking), and decodes the JSON response.
+func (s *Server) runFetcher(ctx context.Context, f config.Fetcher, headers map[string]string) (any, error) {
+	url, err := renderURL(f, headers)
+	if err != nil {
+		return nil, fmt.Errorf("rendering url template: %w", err)
+	}
+
+	method := f.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	fetchCtx := ctx
+	if f.Timeout > 0 {
+		var cancel func()
+		fetchCtx, cancel = context.WithTimeout(ctx, f.Timeout.Duration())
+		defer cancel()
+	}
+
+	fetchCtx, span := s.Tracer.StartUpstreamSpan(fetchCtx, f.Name)
+	defer span.End()
+
+	s.Log.Info("fetching from upstream", zap.String("fetcher", f.Name), zap.String("url", url))
+
+	start := time.Now()
+	resp, err := s.Client.Do(fetchCtx, f.Name, method, url, s.Tracer.Propagate(fetchCtx))
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	s.Metrics.UpstreamDuration.WithLabelValues(f.Name, status).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data any
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("decoding upstream response: %w", err)
+	}
+	return data, nil
+}
+
+func renderURL(f config.Fetcher, headers map[string]string) (string, error) {
+	tmpl, err := template.New(f.Name).Parse(f.URLTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, config.TemplateContext{Headers: headers}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// mergeResult folds one fetcher's decoded result into the aggregated
+// payload according to its configured merge strategy.
+func mergeResult(aggregated map[string]any, f config.Fetcher, data any) {
+	key := f.TargetField
+	if key == "" {
+		key = f.Name
+	}
+
+	switch f.Merge {
+	case config.MergeAppend:
+		existing, _ := aggregated[key].([]any)
+		items, ok := data.([]any)
+		if !ok {
+			items = []any{data}
+		}
+		aggregated[key] = append(existing, items...)
+
+	case config.MergeJSONPath:
+		setJSONPath(aggregated, key, data)
+
+	default: // config.MergeKey and unset
+		aggregated[key] = data
+	}
+}
+
+// setJSONPath sets value at the dotted path (e.g. "profile.albums") within
+// root, creating intermediate objects as needed.
+func setJSONPath(root map[string]any, path string, value any) {
+	if path == "" {
+		return
+	}
+
+	parts := strings.Split(path, ".")
+	cur := root
+	for _, p := range parts[:len(parts)-1] {
+		next, ok := cur[p].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			cur[p] = next
+		}
+		cur = next
+	}
+	cur[parts[len(parts)-1]] = value
+}
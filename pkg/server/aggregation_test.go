@@ -0,0 +1,126 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/config"
+)
+
+func TestMergeResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		seed   map[string]any
+		f      config.Fetcher
+		data   any
+		expect map[string]any
+	}{
+		{
+			name:   "default merge key uses fetcher name",
+			seed:   map[string]any{},
+			f:      config.Fetcher{Name: "users"},
+			data:   map[string]any{"id": "1"},
+			expect: map[string]any{"users": map[string]any{"id": "1"}},
+		},
+		{
+			name:   "merge key honours TargetField over Name",
+			seed:   map[string]any{},
+			f:      config.Fetcher{Name: "users", TargetField: "profile"},
+			data:   map[string]any{"id": "1"},
+			expect: map[string]any{"profile": map[string]any{"id": "1"}},
+		},
+		{
+			name: "append merge creates the array if absent",
+			seed: map[string]any{},
+			f:    config.Fetcher{Name: "albums", Merge: config.MergeAppend},
+			data: []any{"a", "b"},
+			expect: map[string]any{
+				"albums": []any{"a", "b"},
+			},
+		},
+		{
+			name: "append merge extends an existing array",
+			seed: map[string]any{"albums": []any{"a"}},
+			f:    config.Fetcher{Name: "albums", Merge: config.MergeAppend},
+			data: []any{"b"},
+			expect: map[string]any{
+				"albums": []any{"a", "b"},
+			},
+		},
+		{
+			name: "append merge wraps a non-array result as a single item",
+			seed: map[string]any{},
+			f:    config.Fetcher{Name: "albums", Merge: config.MergeAppend},
+			data: "a",
+			expect: map[string]any{
+				"albums": []any{"a"},
+			},
+		},
+		{
+			name: "jsonpath merge sets a dotted path",
+			seed: map[string]any{},
+			f:    config.Fetcher{Name: "albums", TargetField: "profile.albums", Merge: config.MergeJSONPath},
+			data: []any{"a"},
+			expect: map[string]any{
+				"profile": map[string]any{"albums": []any{"a"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergeResult(tt.seed, tt.f, tt.data)
+			if !reflect.DeepEqual(tt.seed, tt.expect) {
+				t.Errorf("mergeResult() = %v, want %v", tt.seed, tt.expect)
+			}
+		})
+	}
+}
+
+func TestSetJSONPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		seed   map[string]any
+		path   string
+		value  any
+		expect map[string]any
+	}{
+		{
+			name:   "empty path is a no-op",
+			seed:   map[string]any{"a": 1},
+			path:   "",
+			value:  2,
+			expect: map[string]any{"a": 1},
+		},
+		{
+			name:   "single segment path sets a top-level key",
+			seed:   map[string]any{},
+			path:   "albums",
+			value:  []any{"a"},
+			expect: map[string]any{"albums": []any{"a"}},
+		},
+		{
+			name:   "nested path creates intermediate objects",
+			seed:   map[string]any{},
+			path:   "profile.albums",
+			value:  []any{"a"},
+			expect: map[string]any{"profile": map[string]any{"albums": []any{"a"}}},
+		},
+		{
+			name:   "nested path reuses an existing intermediate object",
+			seed:   map[string]any{"profile": map[string]any{"name": "bob"}},
+			path:   "profile.albums",
+			value:  []any{"a"},
+			expect: map[string]any{"profile": map[string]any{"name": "bob", "albums": []any{"a"}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setJSONPath(tt.seed, tt.path, tt.value)
+			if !reflect.DeepEqual(tt.seed, tt.expect) {
+				t.Errorf("setJSONPath() = %v, want %v", tt.seed, tt.expect)
+			}
+		})
+	}
+}
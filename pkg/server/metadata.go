@@ -0,0 +1,82 @@
+package server
+
+import (
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// metadataNamespace is the filter_metadata/typed_filter_metadata
+	// namespace this server reads its per-request profile selector from and
+	// writes DynamicMetadata to, e.g.
+	// filter_metadata: { "aggregation": { "profile": "albums_only" } }.
+	metadataNamespace = "aggregation"
+
+	// metadataProfileKey is the field, within metadataNamespace, naming the
+	// config.AggregationConfig profile to use for this request.
+	metadataProfileKey = "profile"
+)
+
+// profileFromMetadata extracts the aggregation profile selected for this
+// request from Envoy's MetadataContext, checking filter_metadata first and
+// falling back to typed_filter_metadata entries carrying a
+// google.protobuf.Struct. Returns "" if no profile was set, in which case
+// every configured fetcher is used.
+func profileFromMetadata(md *corev3.Metadata) string {
+	if md == nil {
+		return ""
+	}
+
+	if s, ok := md.GetFilterMetadata()[metadataNamespace]; ok {
+		if v, ok := s.GetFields()[metadataProfileKey]; ok {
+			return v.GetStringValue()
+		}
+	}
+
+	if a, ok := md.GetTypedFilterMetadata()[metadataNamespace]; ok {
+		var s structpb.Struct
+		if err := a.UnmarshalTo(&s); err == nil {
+			if v, ok := s.Fields[metadataProfileKey]; ok {
+				return v.GetStringValue()
+			}
+		}
+	}
+
+	return ""
+}
+
+// aggregationOutcome carries the bookkeeping from a single aggregation
+// attempt that's surfaced back to Envoy as DynamicMetadata.
+type aggregationOutcome struct {
+	// Body is the aggregated JSON payload, or "" if no fetchers ran.
+	Body string
+
+	// UserID is the resolved userid the request was aggregated for.
+	UserID string
+
+	// CacheResult is one of "hit", "miss" or "bypass".
+	CacheResult string
+
+	// FetcherErrors is how many configured fetchers failed.
+	FetcherErrors int
+}
+
+// dynamicMetadata builds the DynamicMetadata struct returned to Envoy under
+// metadataNamespace, so other filters and access logs can key off this
+// request's aggregation outcome.
+func dynamicMetadata(outcome aggregationOutcome, elapsed time.Duration) *structpb.Struct {
+	s, err := structpb.NewStruct(map[string]any{
+		metadataNamespace: map[string]any{
+			"userid":              outcome.UserID,
+			"cache_result":        outcome.CacheResult,
+			"fetcher_errors":      float64(outcome.FetcherErrors),
+			"upstream_latency_ms": float64(elapsed.Milliseconds()),
+		},
+	})
+	if err != nil {
+		return nil
+	}
+	return s
+}
@@ -1,41 +1,75 @@
 package server
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/auth"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/cache"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/client"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/config"
+	"github.com/day0ops/ext-proc-service-aggregation/pkg/observability"
+	extprocv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	service_ext_proc_v3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
 	"io"
 	"net/http"
-	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type AlbumResponse struct {
-	Id     int    `json:"id"`
-	UserId int    `json:"userId"`
-	Title  string `json:"title"`
-}
-
-type PostResponse struct {
-	Id     int    `json:"id"`
-	UserId int    `json:"userId"`
-	Title  string `json:"title"`
-	Body   string `json:"body"`
-}
-
-type AggregatedData struct {
-	Albums []AlbumResponse `json:"albums"`
-	Posts  []PostResponse  `json:"posts"`
-}
-
 type Server struct {
 	Log *zap.Logger
+
+	// ProcessingMode controls which ext_proc phases beyond request headers
+	// this server participates in, and how bodies are streamed to it.
+	ProcessingMode config.ProcessingModeConfig
+
+	// Config holds the live declarative aggregation config (the fan-out
+	// plan of upstream fetchers). It is an atomic pointer so that it can be
+	// hot-reloaded without disrupting in-flight streams.
+	Config *atomic.Pointer[config.AggregationConfig]
+
+	// Client makes the outbound calls to configured fetchers, applying
+	// shared timeouts, retries, a circuit breaker per upstream, and a cap
+	// on concurrent outbound requests.
+	Client *client.Client
+
+	// Observability controls the slow-request warn-log threshold; metrics
+	// and tracing are always instrumented, the config only tunes them.
+	Observability config.ObservabilityConfig
+
+	// Metrics is the set of Prometheus collectors instrumenting Process and
+	// every upstream fetch.
+	Metrics *observability.Metrics
+
+	// Tracer emits OpenTelemetry spans for Process and each upstream fetch,
+	// propagating the incoming traceparent onward.
+	Tracer *observability.Tracer
+
+	// Cache, if non-nil, stores aggregated results in front of
+	// fetchAggregatedResources, keyed by userid and rendered fetcher URLs. Nil
+	// disables caching entirely, so every request fans out to the
+	// configured fetchers.
+	Cache cache.Cache
+
+	// CacheConfig supplies the TTLs Cache entries are stored with; it is
+	// not consulted when Cache is nil.
+	CacheConfig config.CacheConfig
+
+	// sf collapses concurrent aggregation requests for the same cache key
+	// into a single upstream fan-out.
+	sf singleflight.Group
+
+	// UserIDResolver resolves the userid a request is aggregated for,
+	// preferring a JWT claim over the raw "userid" header when configured.
+	UserIDResolver *auth.UserIDResolver
 }
 
 type HealthServer struct {
@@ -51,12 +85,66 @@ func (s *HealthServer) Watch(in *grpc_health_v1.HealthCheckRequest, srv grpc_hea
 	return status.Error(codes.Unimplemented, "watch is not implemented")
 }
 
+// streamSeq hands out a process-wide, monotonically increasing id used to
+// correlate the log lines belonging to a single ext_proc stream (one gRPC
+// Process call, i.e. one HTTP request/response pair).
+var streamSeq atomic.Uint64
+
+func nextStreamID() string {
+	return fmt.Sprintf("stream-%d", streamSeq.Add(1))
+}
+
+// requestPhase names a ProcessingRequest's phase for metrics, matching the
+// oneof field names used throughout Process.
+func requestPhase(req *service_ext_proc_v3.ProcessingRequest) string {
+	switch req.Request.(type) {
+	case *service_ext_proc_v3.ProcessingRequest_RequestHeaders:
+		return "request_headers"
+	case *service_ext_proc_v3.ProcessingRequest_RequestBody:
+		return "request_body"
+	case *service_ext_proc_v3.ProcessingRequest_RequestTrailers:
+		return "request_trailers"
+	case *service_ext_proc_v3.ProcessingRequest_ResponseHeaders:
+		return "response_headers"
+	case *service_ext_proc_v3.ProcessingRequest_ResponseBody:
+		return "response_body"
+	case *service_ext_proc_v3.ProcessingRequest_ResponseTrailers:
+		return "response_trailers"
+	default:
+		return "unknown"
+	}
+}
+
+// streamState holds the state that needs to survive across the multiple
+// ProcessingRequest messages making up a single Process call.
+type streamState struct {
+	streamID string
+
+	// responseBody accumulates the response body chunks Envoy streams to
+	// this processor (in STREAMED or BUFFERED_PARTIAL mode) for the current
+	// response, keyed to this stream by streamID. There is no
+	// content-transforming use of it yet - it is replayed back unchanged at
+	// EndOfStream - but the accumulation exists so a future response-body
+	// transform has somewhere to hook in without revisiting the chunking.
+	responseBody bytes.Buffer
+}
+
 func (s *Server) Process(srv service_ext_proc_v3.ExternalProcessor_ProcessServer) error {
 	ctx := srv.Context()
+	state := &streamState{streamID: nextStreamID()}
+	log := s.Log.With(zap.String("stream_id", state.streamID))
+
+	s.Metrics.InFlightStreams.Inc()
+	defer s.Metrics.InFlightStreams.Dec()
+
+	// requestBodyReplaced records whether the header phase replaced the
+	// request body, so later RequestBody chunks are only cleared then.
+	requestBodyReplaced := false
+
 	for {
 		select {
 		case <-ctx.Done():
-			s.Log.Debug("context done")
+			log.Debug("context done")
 			return ctx.Err()
 		default:
 		}
@@ -70,176 +158,257 @@ func (s *Server) Process(srv service_ext_proc_v3.ExternalProcessor_ProcessServer
 			return status.Errorf(codes.Unknown, "cannot receive stream request: %v", err)
 		}
 
+		s.Metrics.RequestsByPhase.WithLabelValues(requestPhase(req)).Inc()
+
 		// build response based on request type
-		resp := &service_ext_proc_v3.ProcessingResponse{}
+		var resp *service_ext_proc_v3.ProcessingResponse
 		switch v := req.Request.(type) {
 		case *service_ext_proc_v3.ProcessingRequest_RequestHeaders:
 			h := req.Request.(*service_ext_proc_v3.ProcessingRequest_RequestHeaders)
-			headersResp, err := s.aggregateServices(h.RequestHeaders)
+			profile := profileFromMetadata(req.GetMetadataContext())
+			aggStart := time.Now()
+			headersResp, outcome, err := s.aggregateServices(ctx, h.RequestHeaders, profile)
+			elapsed := time.Since(aggStart)
+			s.Metrics.AggregationDuration.Observe(elapsed.Seconds())
+			if threshold := s.Observability.SlowRequestThreshold; threshold > 0 {
+				if elapsed > threshold {
+					log.Warn("slow aggregation request", zap.Duration("elapsed", elapsed), zap.Duration("threshold", threshold))
+				}
+			}
 			if err != nil {
-				return err
+				log.Error("aggregation failed", zap.Error(err))
+				resp = immediateResponseForError(err, outcome, elapsed)
+				break
 			}
+			requestBodyReplaced = outcome.Body != ""
 			resp = &service_ext_proc_v3.ProcessingResponse{
 				Response: &service_ext_proc_v3.ProcessingResponse_RequestHeaders{
 					RequestHeaders: headersResp,
 				},
+				ModeOverride:    s.modeOverride(),
+				DynamicMetadata: dynamicMetadata(outcome, elapsed),
 			}
 
 		case *service_ext_proc_v3.ProcessingRequest_RequestBody:
-			s.Log.Debug("got RequestBody (not currently implemented)")
+			h := req.Request.(*service_ext_proc_v3.ProcessingRequest_RequestBody)
+			log.Debug("got RequestBody", zap.Int("chunk_bytes", len(h.RequestBody.Body)), zap.Bool("end_of_stream", h.RequestBody.EndOfStream))
+			bodyResp := &service_ext_proc_v3.BodyResponse{}
+			if requestBodyReplaced {
+				bodyResp = clearStaleRequestBody()
+			}
+			resp = &service_ext_proc_v3.ProcessingResponse{
+				Response: &service_ext_proc_v3.ProcessingResponse_RequestBody{
+					RequestBody: bodyResp,
+				},
+			}
 
 		case *service_ext_proc_v3.ProcessingRequest_RequestTrailers:
-			s.Log.Debug("got RequestTrailers (not currently implemented)")
+			log.Debug("got RequestTrailers")
+			resp = &service_ext_proc_v3.ProcessingResponse{
+				Response: &service_ext_proc_v3.ProcessingResponse_RequestTrailers{
+					RequestTrailers: &service_ext_proc_v3.TrailersResponse{},
+				},
+			}
 
 		case *service_ext_proc_v3.ProcessingRequest_ResponseHeaders:
-			s.Log.Debug("got ResponseHeaders (not currently implemented)")
+			log.Debug("got ResponseHeaders")
+			resp = &service_ext_proc_v3.ProcessingResponse{
+				Response: &service_ext_proc_v3.ProcessingResponse_ResponseHeaders{
+					ResponseHeaders: &service_ext_proc_v3.HeadersResponse{},
+				},
+			}
 
 		case *service_ext_proc_v3.ProcessingRequest_ResponseBody:
-			s.Log.Debug("got ResponseBody (not currently implemented)")
+			h := req.Request.(*service_ext_proc_v3.ProcessingRequest_ResponseBody)
+			log.Debug("got ResponseBody", zap.Int("chunk_bytes", len(h.ResponseBody.Body)), zap.Bool("end_of_stream", h.ResponseBody.EndOfStream))
+			resp = &service_ext_proc_v3.ProcessingResponse{
+				Response: &service_ext_proc_v3.ProcessingResponse_ResponseBody{
+					ResponseBody: state.accumulateResponseBody(h.ResponseBody),
+				},
+			}
 
 		case *service_ext_proc_v3.ProcessingRequest_ResponseTrailers:
-			s.Log.Debug("got ResponseTrailers (not currently handled)")
+			log.Debug("got ResponseTrailers")
+			resp = &service_ext_proc_v3.ProcessingResponse{
+				Response: &service_ext_proc_v3.ProcessingResponse_ResponseTrailers{
+					ResponseTrailers: &service_ext_proc_v3.TrailersResponse{},
+				},
+			}
 
 		default:
-			s.Log.Error("unknown Request type", zap.Any("v", v))
+			log.Error("unknown Request type", zap.Any("v", v))
+			resp = &service_ext_proc_v3.ProcessingResponse{}
 		}
 
 		// At this point we believe we have created a valid response...
 		// note that this is sometimes not the case
 		// anyways for now just send it
-		s.Log.Debug("sending ProcessingResponse")
+		log.Debug("sending ProcessingResponse")
 		if err := srv.Send(resp); err != nil {
-			s.Log.Error("send error", zap.Error(err))
+			log.Error("send error", zap.Error(err))
 			return err
 		}
 
 	}
 }
 
-// get the user id from the list of headers
-func (s *Server) getUserIdFromHeaders(in *service_ext_proc_v3.HttpHeaders) string {
-	for _, n := range in.Headers.Headers {
-		if strings.ToLower(n.Key) == "userid" {
-			return string(n.RawValue)
-		}
+// modeOverride tells Envoy which request/response phases to send to this
+// processor for the rest of the current stream, based on s.ProcessingMode.
+// It is only honoured by Envoy when allow_mode_override is set on the
+// ext_proc filter config; returning nil leaves the filter's static
+// configuration in place.
+func (s *Server) modeOverride() *extprocv3.ProcessingMode {
+	pm := s.ProcessingMode
+	if pm.RequestBodyMode == "" && pm.ResponseBodyMode == "" && !pm.ProcessResponseHeaders && !pm.ProcessResponseTrailers {
+		return nil
 	}
-	return ""
-}
 
-func (s *Server) aggregateServices(in *service_ext_proc_v3.HttpHeaders) (*service_ext_proc_v3.HeadersResponse, error) {
-	userIdString := s.getUserIdFromHeaders(in)
-
-	// no instructions were sent, so don't modify anything
-	if userIdString == "" {
-		return &service_ext_proc_v3.HeadersResponse{}, nil
+	headerMode := func(enabled bool) extprocv3.ProcessingMode_HeaderSendMode {
+		if enabled {
+			return extprocv3.ProcessingMode_SEND
+		}
+		return extprocv3.ProcessingMode_SKIP
 	}
 
-	// build the response
-	resp := &service_ext_proc_v3.HeadersResponse{
-		Response: &service_ext_proc_v3.CommonResponse{},
+	return &extprocv3.ProcessingMode{
+		RequestBodyMode:     bodySendMode(pm.RequestBodyMode),
+		ResponseBodyMode:    bodySendMode(pm.ResponseBodyMode),
+		ResponseHeaderMode:  headerMode(pm.ProcessResponseHeaders),
+		ResponseTrailerMode: headerMode(pm.ProcessResponseTrailers),
 	}
+}
 
-	// required when mutating the body based on a header request
-	resp.Response.Status = service_ext_proc_v3.CommonResponse_CONTINUE_AND_REPLACE
-
-	body := s.fetchAggregatedResources(userIdString)
-	resp.Response.BodyMutation = &service_ext_proc_v3.BodyMutation{
-		Mutation: &service_ext_proc_v3.BodyMutation_Body{
-			Body: []byte(body),
-		},
+func bodySendMode(mode config.BodySendMode) extprocv3.ProcessingMode_BodySendMode {
+	switch mode {
+	case config.BodySendModeStreamed:
+		return extprocv3.ProcessingMode_STREAMED
+	case config.BodySendModeBuffered:
+		return extprocv3.ProcessingMode_BUFFERED
+	case config.BodySendModeBufferedPartial:
+		return extprocv3.ProcessingMode_BUFFERED_PARTIAL
+	default:
+		return extprocv3.ProcessingMode_NONE
 	}
-
-	return resp, nil
 }
 
-// fetch the albums given a user id
-func (s *Server) fetchAlbums(id string, wg *sync.WaitGroup) []AlbumResponse {
-	defer wg.Done()
-
-	s.Log.Info("fetching Albums for user", zap.String("user", id))
-	url := fmt.Sprintf("https://jsonplaceholder.typicode.com/users/%s/albums", id)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		s.Log.Fatal("error loading Albums", zap.String("url", url), zap.Error(err))
+// clearStaleRequestBody builds the BodyResponse sent for each RequestBody
+// chunk once aggregateServices has replaced the request body wholesale via
+// CONTINUE_AND_REPLACE when it answered the header phase; any request
+// body chunks Envoy still streams afterward (because a body mode is
+// enabled) are the stale original body; clearing them here keeps Envoy from
+// concatenating the original body onto the replacement.
+func clearStaleRequestBody() *service_ext_proc_v3.BodyResponse {
+	return &service_ext_proc_v3.BodyResponse{
+		Response: &service_ext_proc_v3.CommonResponse{
+			BodyMutation: &service_ext_proc_v3.BodyMutation{
+				Mutation: &service_ext_proc_v3.BodyMutation_ClearBody{
+					ClearBody: true,
+				},
+			},
+		},
 	}
+}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(err)
+// accumulateResponseBody folds one ResponseBody chunk into s.responseBody,
+// keyed to the enclosing stream by virtue of s being that stream's
+// streamState, and returns the BodyResponse sent back for it. Intermediate
+// chunks (more streamed/buffered_partial chunks still to come) are withheld
+// from Envoy via ClearBody, since forwarding them now and the accumulated
+// body again at EndOfStream would duplicate the response; EndOfStream
+// replays the full accumulated body as a single BodyMutation_Body. There is
+// no content transformation applied to it yet - this only gives a future
+// one somewhere to plug in without redoing the accumulation.
+func (s *streamState) accumulateResponseBody(body *service_ext_proc_v3.HttpBody) *service_ext_proc_v3.BodyResponse {
+	s.responseBody.Write(body.Body)
+
+	if !body.EndOfStream {
+		return &service_ext_proc_v3.BodyResponse{
+			Response: &service_ext_proc_v3.CommonResponse{
+				BodyMutation: &service_ext_proc_v3.BodyMutation{
+					Mutation: &service_ext_proc_v3.BodyMutation_ClearBody{
+						ClearBody: true,
+					},
+				},
+			},
 		}
-	}(resp.Body)
-
-	var albumResp []AlbumResponse
-	decodeErr := json.NewDecoder(resp.Body).Decode(&albumResp)
-	if decodeErr != nil {
-		s.Log.Fatal("error decoding Albums response", zap.Error(err))
 	}
 
-	return albumResp
+	return &service_ext_proc_v3.BodyResponse{
+		Response: &service_ext_proc_v3.CommonResponse{
+			BodyMutation: &service_ext_proc_v3.BodyMutation{
+				Mutation: &service_ext_proc_v3.BodyMutation_Body{
+					Body: s.responseBody.Bytes(),
+				},
+			},
+		},
+	}
 }
 
-// fetch the posts given a user id
-func (s *Server) fetchPosts(id string, wg *sync.WaitGroup) []PostResponse {
-	defer wg.Done()
-
-	s.Log.Info("fetching Posts for user", zap.String("user", id))
-	url := fmt.Sprintf("https://jsonplaceholder.typicode.com/users/%s/posts", id)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		s.Log.Fatal("error loading Posts", zap.String("url", url), zap.Error(err))
+func immediateResponse(code typev3.StatusCode, details string) *service_ext_proc_v3.ProcessingResponse {
+	return &service_ext_proc_v3.ProcessingResponse{
+		Response: &service_ext_proc_v3.ProcessingResponse_ImmediateResponse{
+			ImmediateResponse: &service_ext_proc_v3.ImmediateResponse{
+				Status:  &typev3.HttpStatus{Code: code},
+				Details: details,
+			},
+		},
 	}
+}
 
-	defer func(Body io.ReadCloser) {
-		err := Body.Close()
-		if err != nil {
-			panic(err)
-		}
-	}(resp.Body)
-
-	var postResp []PostResponse
-	decodeErr := json.NewDecoder(resp.Body).Decode(&postResp)
-	if decodeErr != nil {
-		s.Log.Error("error decoding Posts response", zap.Error(err))
+// immediateResponseForError turns an aggregation failure into an
+// ImmediateResponse instead of tearing down the whole ext_proc stream (or,
+// as with the client's previous use of log.Fatal, the whole server). A
+// *client.Error reporting a timeout becomes a 504; anything else is a 502,
+// matching the respective upstream semantics. outcome and elapsed are
+// still reported as DynamicMetadata on the enclosing ProcessingResponse, so
+// a failed aggregation's fetcher-error count and cache result aren't lost
+// just because the request path is aborting.
+func immediateResponseForError(err error, outcome aggregationOutcome, elapsed time.Duration) *service_ext_proc_v3.ProcessingResponse {
+	code := typev3.StatusCode_BadGateway
+	var clientErr *client.Error
+	if errors.As(err, &clientErr) && clientErr.StatusCode() == http.StatusGatewayTimeout {
+		code = typev3.StatusCode_GatewayTimeout
 	}
-	return postResp
+	resp := immediateResponse(code, err.Error())
+	resp.DynamicMetadata = dynamicMetadata(outcome, elapsed)
+	return resp
 }
 
-func (s *Server) fetchAggregatedResources(id string) string {
-	start := time.Now()
+// aggregateServices renders profile's fetcher plan (see
+// config.AggregationConfig.FetchersForProfile) against the request's
+// headers and, if any fetchers are configured, replaces the request body
+// with the aggregated result. The returned aggregationOutcome is reported
+// regardless of error, so callers can surface it as DynamicMetadata.
+func (s *Server) aggregateServices(ctx context.Context, in *service_ext_proc_v3.HttpHeaders, profile string) (*service_ext_proc_v3.HeadersResponse, aggregationOutcome, error) {
+	headers := headersToMap(in)
+	headers["userid"] = s.UserIDResolver.Resolve(headers)
 
-	var wg sync.WaitGroup
+	ctx, span := s.Tracer.StartRequestSpan(ctx, headers)
+	defer span.End()
 
-	var albumsResp []AlbumResponse
-	var postsResp []PostResponse
-
-	wg.Add(1)
-	go func(id string) {
-		albumsResp = s.fetchAlbums(id, &wg)
-	}(id)
-
-	wg.Add(1)
-	go func(id string) {
-		postsResp = s.fetchPosts(id, &wg)
-	}(id)
-
-	wg.Wait()
+	outcome, err := s.fetchAggregatedResources(ctx, headers, profile)
+	outcome.UserID = headers["userid"]
+	if err != nil {
+		return nil, outcome, err
+	}
+	if outcome.Body == "" {
+		// no fetchers are configured, so don't modify anything
+		return &service_ext_proc_v3.HeadersResponse{}, outcome, nil
+	}
 
-	end := time.Now()
-	duration := end.Sub(start)
-	s.Log.Info("fetching took", zap.Duration("duration", duration))
+	s.Metrics.BodyMutationBytes.Observe(float64(len(outcome.Body)))
 
-	aggregatedData := AggregatedData{}
-	aggregatedData.Albums = albumsResp
-	aggregatedData.Posts = postsResp
+	resp := &service_ext_proc_v3.HeadersResponse{
+		Response: &service_ext_proc_v3.CommonResponse{},
+	}
 
-	data, err := json.Marshal(aggregatedData)
-	if err != nil {
-		s.Log.Error("error marshalling aggregated data", zap.Error(err))
-		return ""
+	// required when mutating the body based on a header request
+	resp.Response.Status = service_ext_proc_v3.CommonResponse_CONTINUE_AND_REPLACE
+	resp.Response.BodyMutation = &service_ext_proc_v3.BodyMutation{
+		Mutation: &service_ext_proc_v3.BodyMutation_Body{
+			Body: []byte(outcome.Body),
+		},
 	}
 
-	return string(data)
+	return resp, outcome, nil
 }
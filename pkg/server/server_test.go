@@ -0,0 +1,48 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	service_ext_proc_v3 "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+)
+
+func TestAccumulateResponseBodyWithholdsIntermediateChunks(t *testing.T) {
+	state := &streamState{}
+
+	resp := state.accumulateResponseBody(&service_ext_proc_v3.HttpBody{Body: []byte("hello "), EndOfStream: false})
+
+	clear, ok := resp.Response.BodyMutation.Mutation.(*service_ext_proc_v3.BodyMutation_ClearBody)
+	if !ok || !clear.ClearBody {
+		t.Fatalf("response mutation = %#v, want a ClearBody mutation for a non-final chunk", resp.Response.BodyMutation.Mutation)
+	}
+}
+
+func TestAccumulateResponseBodyReplaysFullBodyAtEndOfStream(t *testing.T) {
+	state := &streamState{}
+	state.accumulateResponseBody(&service_ext_proc_v3.HttpBody{Body: []byte("hello "), EndOfStream: false})
+
+	resp := state.accumulateResponseBody(&service_ext_proc_v3.HttpBody{Body: []byte("world"), EndOfStream: true})
+
+	mutation, ok := resp.Response.BodyMutation.Mutation.(*service_ext_proc_v3.BodyMutation_Body)
+	if !ok {
+		t.Fatalf("response mutation = %#v, want a Body mutation at EndOfStream", resp.Response.BodyMutation.Mutation)
+	}
+	if !bytes.Equal(mutation.Body, []byte("hello world")) {
+		t.Errorf("mutation.Body = %q, want %q", mutation.Body, "hello world")
+	}
+}
+
+func TestAccumulateResponseBodySingleChunkEndOfStream(t *testing.T) {
+	state := &streamState{}
+
+	resp := state.accumulateResponseBody(&service_ext_proc_v3.HttpBody{Body: []byte("hello"), EndOfStream: true})
+
+	mutation, ok := resp.Response.BodyMutation.Mutation.(*service_ext_proc_v3.BodyMutation_Body)
+	if !ok {
+		t.Fatalf("response mutation = %#v, want a Body mutation at EndOfStream", resp.Response.BodyMutation.Mutation)
+	}
+	if !bytes.Equal(mutation.Body, []byte("hello")) {
+		t.Errorf("mutation.Body = %q, want %q", mutation.Body, "hello")
+	}
+}
@@ -0,0 +1,6 @@
+package version
+
+// HumanVersion is the version reported in logs and, eventually, a
+// --version flag. It is overridden at build time via -ldflags
+// "-X github.com/day0ops/ext-proc-service-aggregation/pkg/version.HumanVersion=...".
+var HumanVersion = "dev"